@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// verifyDigest checks that data hashes to the expected "sha256:hex" digest.
+func verifyDigest(data []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	got := "sha256:" + hex.EncodeToString(sum[:])
+	if !strings.EqualFold(got, expected) {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", expected, got)
+	}
+	return nil
+}
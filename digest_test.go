@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestVerifyDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		expect  string
+		wantErr bool
+	}{
+		{
+			name:   "matching digest",
+			data:   "hello",
+			expect: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:   "matching digest is case-insensitive",
+			data:   "hello",
+			expect: "sha256:2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824",
+		},
+		{
+			name:   "empty expected digest skips verification",
+			data:   "anything",
+			expect: "",
+		},
+		{
+			name:    "mismatched digest",
+			data:    "hello",
+			expect:  "sha256:0000000000000000000000000000000000000000000000000000000000000",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDigest([]byte(tt.data), tt.expect)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyDigest(%q, %q) error = %v, wantErr %v", tt.data, tt.expect, err, tt.wantErr)
+			}
+		})
+	}
+}
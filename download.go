@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// downloadBlob fetches a content-addressed blob into path. If path already
+// holds content matching digest, the request is skipped entirely. If a
+// partial file is present, the download resumes with a Range request. Any
+// progress, if p is non-nil, is reported as bytes are written.
+func downloadBlob(registry Registry, authHeader, repo, digest, path string, p *progressReporter) error {
+	if fileMatchesDigest(path, digest) {
+		if fi, err := os.Stat(path); err == nil && p != nil {
+			p.skip(fi.Size())
+		}
+		return nil
+	}
+
+	blobUrl := fmt.Sprintf("%s/v2/%s/blobs/%s", registry.String(), repo, digest)
+	req, err := http.NewRequest("GET", blobUrl, nil)
+	if err != nil {
+		return err
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	var resumeFrom int64
+	if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+		resumeFrom = fi.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+
+	resp, err := httpDo(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The bearer token from authHeader may simply have expired mid-pull
+		// (Docker Hub's default TTL is 300s); retry once with a forced
+		// refresh before giving up on the blob.
+		resp.Body.Close()
+		scope := fmt.Sprintf("repository:%s:pull", repo)
+		refreshed, refreshErr := refreshAuthToken(registry, scope)
+		if refreshErr != nil {
+			return fmt.Errorf("blob %s: refreshing expired token: %w", digest, refreshErr)
+		}
+		if refreshed != "" {
+			req.Header.Set("Authorization", refreshed)
+		} else {
+			req.Header.Del("Authorization")
+		}
+		resp, err = httpDo(req)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	openFlags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The registry ignored our Range request and is sending the whole
+		// blob again, so the old partial bytes no longer count.
+		openFlags |= os.O_TRUNC
+		resumeFrom = 0
+	case http.StatusPartialContent:
+		openFlags |= os.O_APPEND
+	default:
+		return fmt.Errorf("failed to download blob %s: %s", digest, resp.Status)
+	}
+
+	// Hash the response body inline with a TeeReader instead of writing it
+	// to disk and re-reading the whole file afterwards. When resuming, the
+	// bytes already on disk have to be folded in first, but that's the
+	// resumed portion only, not the whole blob.
+	hasher := sha256.New()
+	if openFlags&os.O_APPEND != 0 {
+		if err := hashExistingFile(path, hasher); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, openFlags, 0644)
+	if err != nil {
+		return err
+	}
+
+	dest := io.Writer(file)
+	if p != nil {
+		dest = io.MultiWriter(file, p.counter())
+	}
+	remaining := defaultTransport.MaxBlobBytes - resumeFrom
+	limit := io.LimitReader(resp.Body, remaining+1)
+	written, copyErr := io.Copy(dest, io.TeeReader(limit, hasher))
+	closeErr := file.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if written > remaining {
+		_ = os.Remove(path)
+		return fmt.Errorf("blob %s exceeds the %d byte limit", digest, defaultTransport.MaxBlobBytes)
+	}
+
+	if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(got, digest) {
+		_ = os.Remove(path)
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s", path, digest, got)
+	}
+	return nil
+}
+
+// hashExistingFile feeds path's current content into hasher, used to fold
+// already-downloaded bytes of a resumed blob into the running digest before
+// the new bytes from the Range response are appended.
+func hashExistingFile(path string, hasher io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(hasher, f)
+	return err
+}
+
+// fileMatchesDigest reports whether path exists and its content hashes to digest.
+func fileMatchesDigest(path, digest string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false
+	}
+	got := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	return strings.EqualFold(got, digest)
+}
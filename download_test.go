@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTestRegistry starts a TLS test server behind the given handler and
+// points a Registry at it, marking the host insecure on defaultTransport (it
+// presents a self-signed cert) so downloadBlob's real network path can be
+// exercised end-to-end. The host's original insecure setting is restored on
+// cleanup.
+func withTestRegistry(t *testing.T, handler http.HandlerFunc) Registry {
+	t.Helper()
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	prevInsecure := defaultTransport.InsecureHosts[host]
+	defaultTransport.markInsecure(host)
+	t.Cleanup(func() {
+		if !prevInsecure {
+			delete(defaultTransport.InsecureHosts, host)
+		}
+	})
+
+	return Registry{Host: host}
+}
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestDownloadBlobSkipsWhenFileAlreadyMatchesDigest(t *testing.T) {
+	dir := t.TempDir()
+	content := "already here"
+	path := filepath.Join(dir, "blob")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("server should not be contacted when the blob already matches")
+	})
+
+	if err := downloadBlob(registry, "", "repo", digestOf(content), path, nil); err != nil {
+		t.Fatalf("downloadBlob() error = %v", err)
+	}
+}
+
+func TestDownloadBlobResumesPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	full := "0123456789"
+	path := filepath.Join(dir, "blob")
+	if err := os.WriteFile(path, []byte(full[:4]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		if rng != "bytes=4-" {
+			t.Errorf("Range header = %q, want %q", rng, "bytes=4-")
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 4-%d/%d", len(full)-1, len(full)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte(full[4:]))
+	})
+
+	if err := downloadBlob(registry, "", "repo", digestOf(full), path, nil); err != nil {
+		t.Fatalf("downloadBlob() error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != full {
+		t.Errorf("resumed file = %q, want %q", got, full)
+	}
+}
+
+func TestDownloadBlobResetsResumeWhenServerIgnoresRange(t *testing.T) {
+	dir := t.TempDir()
+	full := "0123456789"
+	path := filepath.Join(dir, "blob")
+	// A stale partial file from an earlier attempt.
+	if err := os.WriteFile(path, []byte("garbage..."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		// Ignore the Range request and resend the whole blob, as some
+		// registries do.
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(full))
+	})
+
+	savedLimit := defaultTransport.MaxBlobBytes
+	defaultTransport.MaxBlobBytes = int64(len(full))
+	t.Cleanup(func() { defaultTransport.MaxBlobBytes = savedLimit })
+
+	if err := downloadBlob(registry, "", "repo", digestOf(full), path, nil); err != nil {
+		t.Fatalf("downloadBlob() error = %v, want nil (resumeFrom should reset on a fresh 200)", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != full {
+		t.Errorf("file content = %q, want %q", got, full)
+	}
+}
+
+func TestDownloadBlobRetriesOnceAfter401WithRefreshedToken(t *testing.T) {
+	dir := t.TempDir()
+	content := "fresh content"
+	path := filepath.Join(dir, "blob")
+
+	var blobAttempts int
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			// Anonymous access: no challenge, so refreshAuthToken's
+			// probe short-circuits to an empty Authorization header.
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		blobAttempts++
+		if blobAttempts == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(content))
+	})
+
+	if err := downloadBlob(registry, "Bearer stale-token", "repo", digestOf(content), path, nil); err != nil {
+		t.Fatalf("downloadBlob() error = %v", err)
+	}
+	if blobAttempts != 2 {
+		t.Errorf("blob endpoint attempts = %d, want 2 (initial 401, then a retry with a refreshed token)", blobAttempts)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("file content = %q, want %q", got, content)
+	}
+}
+
+func TestDownloadBlobRejectsDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob")
+
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("actual content"))
+	})
+
+	err := downloadBlob(registry, "", "repo", digestOf("expected content"), path, nil)
+	if err == nil {
+		t.Fatal("downloadBlob() error = nil, want digest mismatch error")
+	}
+	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		t.Error("partial file should be removed after a digest mismatch")
+	}
+}
+
+func TestDownloadBlobEnforcesMaxBlobBytes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob")
+	payload := "this blob is too big"
+
+	registry := withTestRegistry(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(payload))
+	})
+
+	savedLimit := defaultTransport.MaxBlobBytes
+	defaultTransport.MaxBlobBytes = int64(len(payload) - 1)
+	t.Cleanup(func() { defaultTransport.MaxBlobBytes = savedLimit })
+
+	err := downloadBlob(registry, "", "repo", digestOf(payload), path, nil)
+	if err == nil {
+		t.Fatal("downloadBlob() error = nil, want byte limit error")
+	}
+	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		t.Error("oversized blob should be removed")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// AuthConfig holds the credentials resolved for a single registry.
+type AuthConfig struct {
+	Username string
+	Password string
+}
+
+// Keychain resolves credentials for a registry host.
+type Keychain interface {
+	Resolve(registry Registry) (AuthConfig, error)
+}
+
+// dockerConfig mirrors the subset of ~/.docker/config.json we care about.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// defaultKeychain reads ~/.docker/config.json, preferring an inline "auths"
+// entry and falling back to credential helpers (credsStore/credHelpers) the
+// same way the Docker CLI does.
+type defaultKeychain struct {
+	mu     sync.Mutex
+	config *dockerConfig
+}
+
+// DefaultKeychain is the Keychain used by fetchAuthToken unless overridden.
+var DefaultKeychain Keychain = &defaultKeychain{}
+
+func (k *defaultKeychain) load() (*dockerConfig, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.config != nil {
+		return k.config, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".docker", "config.json"))
+	if os.IsNotExist(err) {
+		k.config = &dockerConfig{}
+		return k.config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	k.config = &cfg
+	return k.config, nil
+}
+
+// Resolve implements Keychain. It returns a zero AuthConfig (no error) when
+// no credentials are configured for the registry, so callers fall back to
+// anonymous access.
+func (k *defaultKeychain) Resolve(registry Registry) (AuthConfig, error) {
+	cfg, err := k.load()
+	if err != nil {
+		return AuthConfig{}, err
+	}
+
+	if entry, ok := cfg.Auths[registry.Host]; ok && entry.Auth != "" {
+		return decodeBasicAuth(entry.Auth)
+	}
+
+	if helper, ok := cfg.CredHelpers[registry.Host]; ok {
+		return credHelperGet(helper, registry.Host)
+	}
+	if cfg.CredsStore != "" {
+		return credHelperGet(cfg.CredsStore, registry.Host)
+	}
+
+	return AuthConfig{}, nil
+}
+
+func decodeBasicAuth(encoded string) (AuthConfig, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return AuthConfig{}, fmt.Errorf("decoding auth entry: %w", err)
+	}
+	user, pass, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return AuthConfig{}, fmt.Errorf("malformed auth entry")
+	}
+	return AuthConfig{Username: user, Password: pass}, nil
+}
+
+// credHelperGet implements the docker-credential-helper protocol: write the
+// server URL to the helper's stdin and parse the JSON credentials it prints.
+func credHelperGet(helper, serverURL string) (AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	out, err := cmd.Output()
+	if err != nil {
+		return AuthConfig{}, fmt.Errorf("credential helper %q: %w", helper, err)
+	}
+
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return AuthConfig{}, fmt.Errorf("credential helper %q returned invalid JSON: %w", helper, err)
+	}
+	return AuthConfig{Username: resp.Username, Password: resp.Secret}, nil
+}
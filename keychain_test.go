@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDecodeBasicAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    AuthConfig
+		wantErr bool
+	}{
+		{
+			name:    "valid user and password",
+			encoded: "dXNlcjpoMG5rMnQ=", // base64("user:h0nk2t")
+			want:    AuthConfig{Username: "user", Password: "h0nk2t"},
+		},
+		{
+			name:    "empty password after colon",
+			encoded: "dXNlcjo=", // base64("user:")
+			want:    AuthConfig{Username: "user", Password: ""},
+		},
+		{
+			name:    "invalid base64",
+			encoded: "not-base64!!",
+			wantErr: true,
+		},
+		{
+			name:    "decoded value has no colon",
+			encoded: "dXNlcm5hbWU=", // base64("username")
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBasicAuth(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBasicAuth(%q) error = %v, wantErr %v", tt.encoded, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("decodeBasicAuth(%q) = %+v, want %+v", tt.encoded, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePrefersInlineAuthsOverHelpers(t *testing.T) {
+	k := &defaultKeychain{config: &dockerConfig{
+		Auths: map[string]struct {
+			Auth string `json:"auth"`
+		}{
+			"ghcr.io": {Auth: "dXNlcjpoMG5rMnQ="}, // base64("user:h0nk2t")
+		},
+		CredHelpers: map[string]string{"ghcr.io": "should-not-run"},
+		CredsStore:  "should-not-run-either",
+	}}
+
+	got, err := k.Resolve(Registry{Host: "ghcr.io"})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := AuthConfig{Username: "user", Password: "h0nk2t"}
+	if got != want {
+		t.Errorf("Resolve() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveReturnsZeroValueWhenNothingConfigured(t *testing.T) {
+	k := &defaultKeychain{config: &dockerConfig{}}
+	got, err := k.Resolve(Registry{Host: "example.com"})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got != (AuthConfig{}) {
+		t.Errorf("Resolve() = %+v, want zero value", got)
+	}
+}
+
+// withFakeCredentialHelper installs a docker-credential-<name> script on
+// PATH that ignores its stdin and prints a fixed credential JSON blob,
+// mimicking the docker-credential-helper protocol well enough to exercise
+// credHelperGet without a real credential store.
+func withFakeCredentialHelper(t *testing.T, name, username, password string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helper script is POSIX-shell only")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "docker-credential-"+name)
+	contents := "#!/bin/sh\ncat >/dev/null\nprintf '{\"Username\":\"" + username + "\",\"Secret\":\"" + password + "\"}'\n"
+	if err := os.WriteFile(script, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestResolveUsesPerHostCredHelper(t *testing.T) {
+	withFakeCredentialHelper(t, "test-helper", "helper-user", "helper-pass")
+
+	k := &defaultKeychain{config: &dockerConfig{
+		CredHelpers: map[string]string{"quay.io": "test-helper"},
+		CredsStore:  "should-not-run",
+	}}
+
+	got, err := k.Resolve(Registry{Host: "quay.io"})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := AuthConfig{Username: "helper-user", Password: "helper-pass"}
+	if got != want {
+		t.Errorf("Resolve() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveFallsBackToCredsStore(t *testing.T) {
+	withFakeCredentialHelper(t, "store", "store-user", "store-pass")
+
+	k := &defaultKeychain{config: &dockerConfig{CredsStore: "store"}}
+
+	got, err := k.Resolve(Registry{Host: "example.com"})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := AuthConfig{Username: "store-user", Password: "store-pass"}
+	if got != want {
+		t.Errorf("Resolve() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCredHelperGetPropagatesCommandFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helper script is POSIX-shell only")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "docker-credential-broken")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if _, err := credHelperGet("broken", "example.com"); err == nil {
+		t.Error("credHelperGet() error = nil, want error from a failing helper")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// refNameAnnotation is the OCI image-layout annotation that records the
+// reference (tag or digest) the user originally asked for, since index.json
+// descriptors are otherwise anonymous.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// indexDescriptor is one entry of an OCI image-layout index.json's
+// "manifests" array.
+type indexDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// imageIndex is the top-level index.json document, per the OCI Image Layout
+// Specification.
+type imageIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Manifests     []indexDescriptor `json:"manifests"`
+}
+
+// loadIndex reads dir/index.json, returning an empty index if it hasn't
+// been written yet.
+func loadIndex(dir string) (*imageIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		return &imageIndex{SchemaVersion: 2}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var idx imageIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// appendIndexEntry adds entry to dir/index.json, replacing any existing
+// entry with the same ref.name annotation so re-running on the same tag
+// doesn't accumulate duplicates.
+func appendIndexEntry(dir string, entry indexDescriptor) error {
+	idx, err := loadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	refName := entry.Annotations[refNameAnnotation]
+	replaced := false
+	for i, existing := range idx.Manifests {
+		if refName != "" && existing.Annotations[refNameAnnotation] == refName {
+			idx.Manifests[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		idx.Manifests = append(idx.Manifests, entry)
+	}
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// writeBlob stores data under dir/blobs/sha256/<hex> and returns the
+// descriptor fields needed for an index.json entry.
+func writeBlob(dir string, data []byte) (digest string, size int, err error) {
+	sum := sha256.Sum256(data)
+	hexDigest := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", hexDigest), data, 0644); err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hexDigest, len(data), nil
+}
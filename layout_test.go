@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadIndexMissingFileReturnsEmptyIndex(t *testing.T) {
+	idx, err := loadIndex(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadIndex() error = %v", err)
+	}
+	if idx.SchemaVersion != 2 || len(idx.Manifests) != 0 {
+		t.Errorf("loadIndex() = %+v, want empty schemaVersion-2 index", idx)
+	}
+}
+
+func TestAppendIndexEntryAccumulatesDistinctEntries(t *testing.T) {
+	dir := t.TempDir()
+	first := indexDescriptor{Digest: "sha256:aaa", Size: 1, Annotations: map[string]string{refNameAnnotation: "nginx:1.27"}}
+	second := indexDescriptor{Digest: "sha256:bbb", Size: 2, Annotations: map[string]string{refNameAnnotation: "redis:7"}}
+
+	if err := appendIndexEntry(dir, first); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+	if err := appendIndexEntry(dir, second); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+
+	idx, err := loadIndex(dir)
+	if err != nil {
+		t.Fatalf("loadIndex() error = %v", err)
+	}
+	if len(idx.Manifests) != 2 {
+		t.Fatalf("index has %d manifests, want 2: %+v", len(idx.Manifests), idx.Manifests)
+	}
+	if !reflect.DeepEqual(idx.Manifests[0], first) || !reflect.DeepEqual(idx.Manifests[1], second) {
+		t.Errorf("index manifests = %+v, want [%+v, %+v]", idx.Manifests, first, second)
+	}
+}
+
+func TestAppendIndexEntryReplacesExistingRefName(t *testing.T) {
+	dir := t.TempDir()
+	original := indexDescriptor{Digest: "sha256:aaa", Size: 1, Annotations: map[string]string{refNameAnnotation: "nginx:1.27"}}
+	other := indexDescriptor{Digest: "sha256:ccc", Size: 3, Annotations: map[string]string{refNameAnnotation: "redis:7"}}
+	updated := indexDescriptor{Digest: "sha256:bbb", Size: 2, Annotations: map[string]string{refNameAnnotation: "nginx:1.27"}}
+
+	if err := appendIndexEntry(dir, original); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+	if err := appendIndexEntry(dir, other); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+	// Re-pulling the same tag should replace, not duplicate, its entry.
+	if err := appendIndexEntry(dir, updated); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+
+	idx, err := loadIndex(dir)
+	if err != nil {
+		t.Fatalf("loadIndex() error = %v", err)
+	}
+	if len(idx.Manifests) != 2 {
+		t.Fatalf("index has %d manifests, want 2 (replaced, not appended): %+v", len(idx.Manifests), idx.Manifests)
+	}
+	if !reflect.DeepEqual(idx.Manifests[0], updated) {
+		t.Errorf("index.Manifests[0] = %+v, want %+v", idx.Manifests[0], updated)
+	}
+	if !reflect.DeepEqual(idx.Manifests[1], other) {
+		t.Errorf("index.Manifests[1] = %+v, want %+v", idx.Manifests[1], other)
+	}
+}
+
+func TestWriteBlobStoresContentAddressedFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"blobs", "blobs/sha256"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := []byte(`{"hello":"world"}`)
+	digest, size, err := writeBlob(dir, data)
+	if err != nil {
+		t.Fatalf("writeBlob() error = %v", err)
+	}
+	if size != len(data) {
+		t.Errorf("size = %d, want %d", size, len(data))
+	}
+
+	wantDigest := digestOf(string(data))
+	if digest != wantDigest {
+		t.Errorf("digest = %s, want %s", digest, wantDigest)
+	}
+
+	hex := digest[len("sha256:"):]
+	got, err := os.ReadFile(filepath.Join(dir, "blobs", "sha256", hex))
+	if err != nil {
+		t.Fatalf("reading written blob: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("blob content = %q, want %q", got, data)
+	}
+}
+
+func TestLoadIndexRejectsMalformedJSON(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIndex(dir); err == nil {
+		t.Error("loadIndex() error = nil, want error for malformed index.json")
+	}
+}
+
+func TestAppendIndexEntryWritesValidJSON(t *testing.T) {
+	dir := t.TempDir()
+	entry := indexDescriptor{Digest: "sha256:aaa", Size: 1}
+	if err := appendIndexEntry(dir, entry); err != nil {
+		t.Fatalf("appendIndexEntry() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx imageIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatalf("index.json is not valid JSON: %v", err)
+	}
+}
@@ -5,12 +5,11 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
@@ -18,6 +17,7 @@ import (
 type Layer struct {
 	MediaType string `json:"mediaType"`
 	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
 }
 
 type ManifestV2 struct {
@@ -36,24 +36,46 @@ type ManifestV1 struct {
 	} `json:"history"`
 }
 
+type manifestListEntry struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Platform    Platform          `json:"platform"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
 type ManifestList struct {
-	Manifests []struct {
-		Digest   string `json:"digest"`
-		Platform struct {
-			Architecture string `json:"architecture"`
-			Variant      string `json:"variant,omitempty"`
-		} `json:"platform"`
-		Size int `json:"size"`
-	} `json:"manifests"`
+	Manifests []manifestListEntry `json:"manifests"`
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Printf("usage: %s <output-dir> <image[:tag][@digest]> ...", os.Args[0])
+	workers := flag.Int("j", 0, "number of concurrent blob downloads (default: GOMAXPROCS)")
+	platformFlag := flag.String("platform", "", "comma-separated os/arch[/variant] platforms to pull (default: the platform this binary runs on)")
+	insecure := flag.String("insecure", "", "comma-separated registry hosts to contact without TLS verification")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-j N] [--platform os/arch[/variant][,...]] [--insecure host[,...]] <output-dir> <image[:tag][@digest]> ...\n", os.Args[0])
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dir := args[0]
+	images := args[1:]
+
+	platforms, err := resolvePlatforms(*platformFlag)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	dir := os.Args[1]
-	images := os.Args[2:]
+
+	for _, host := range strings.Split(*insecure, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			defaultTransport.markInsecure(host)
+		}
+	}
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "error: failed to create directory: %v\n", err)
@@ -66,62 +88,74 @@ func main() {
 		os.Exit(1)
 	}
 
-	err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte("{\"imageLayoutVersion\": \"1.0.0\"}"), 0644)
-	if err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte("{\"imageLayoutVersion\": \"1.0.0\"}"), 0644); err != nil {
 		panic(err)
 	}
 
+	pool := newDownloadPool(workerCount(*workers))
+
+	var firstErr error
 	for _, imageTag := range images {
-		if err := processImage(dir, imageTag); err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "error: failed to process image %s: %v\n", imageTag, err)
-			os.Exit(1)
+		for _, platform := range platforms {
+			refName := imageTag
+			if len(platforms) > 1 {
+				refName = fmt.Sprintf("%s (%s)", imageTag, platform)
+			}
+			if err := processImage(dir, imageTag, refName, platform, pool); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "error: failed to process image %s for %s: %v\n", imageTag, platform, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
 		}
 	}
 
+	if err := pool.wait(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		os.Exit(1)
+	}
+
 	fmt.Printf("Download of images into '%s' complete.\n", dir)
 	fmt.Println("Use something like the following to load the result into a containerd instance:")
 	fmt.Printf("  tar -cC '%s' . | nerdctl load\n", dir)
 }
 
-func processImage(dir, imageTag string) error {
-	// parse image tag, use latest as default
-	image := strings.Split(imageTag, ":")[0]
-	tag := "latest"
-	if strings.Contains(imageTag, ":") {
-		tag = strings.Split(imageTag, ":")[1]
-	}
-
-	// add prefix library if official image has been passed
-	if !strings.Contains(image, "/") {
-		image = "library/" + image
+func processImage(dir, imageTag, refName string, platform Platform, pool *downloadPool) error {
+	ref, err := ParseReference(imageTag)
+	if err != nil {
+		return err
 	}
+	ref.Registry.Host = defaultTransport.rewriteHost(ref.Registry.Host)
 
-	token, err := fetchAuthToken(image)
+	scope := fmt.Sprintf("repository:%s:pull", ref.Repository)
+	authHeader, err := fetchAuthToken(ref.Registry, scope)
 	if err != nil {
 		return err
 	}
 
-	manifestJson, err := fetchManifest(token, image, tag)
+	manifestResp, err := fetchManifest(ref.Registry, authHeader, ref.Repository, ref.Target())
 	if err != nil {
 		return err
 	}
+	if ref.Digest != "" {
+		if err := verifyDigest(manifestResp.Bytes, ref.Digest); err != nil {
+			return fmt.Errorf("manifest for %s: %w", imageTag, err)
+		}
+	}
 
 	var manifest map[string]interface{}
-	if err := manifestJson.Decode(&manifest); err != nil {
+	if err := json.Unmarshal(manifestResp.Bytes, &manifest); err != nil {
 		return err
 	}
 
-	//manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
-	//if err != nil {
-	//	return err
-	//}
-
-	//fmt.Println("Manifest JSON string in processImage:")
-	//fmt.Println(string(manifestBytes))
-
 	schemaVersion := int(manifest["schemaVersion"].(float64))
 	if schemaVersion == 1 {
-		if err := handleManifestV1(manifest, token, image, dir); err != nil {
+		if err := handleManifestV1(manifest, manifestResp.Bytes, ref.Registry, authHeader, ref.Repository, dir, refName, pool); err != nil {
 			return err
 		}
 	} else if schemaVersion == 2 {
@@ -129,11 +163,24 @@ func processImage(dir, imageTag string) error {
 		switch mediaType {
 		//for nginx
 		case "application/vnd.docker.distribution.manifest.list.v2+json", "application/vnd.oci.image.index.v1+json":
-			if err := handleManifestList(manifest, token, image, dir); err != nil {
+			if err := handleManifestList(manifest, ref.Registry, authHeader, ref.Repository, dir, refName, platform, pool); err != nil {
 				return err
 			}
 		case "application/vnd.docker.distribution.manifest.v2+json", "application/vnd.oci.image.manifest.v1+json":
-			if err := handleManifestV2(manifest, token, image, dir); err != nil {
+			if err := handleManifestV2(manifest, ref.Registry, authHeader, ref.Repository, dir, pool); err != nil {
+				return err
+			}
+			digest, size, err := writeBlob(dir, manifestResp.Bytes)
+			if err != nil {
+				return err
+			}
+			entry := indexDescriptor{
+				MediaType:   mediaType,
+				Digest:      digest,
+				Size:        size,
+				Annotations: map[string]string{refNameAnnotation: refName},
+			}
+			if err := appendIndexEntry(dir, entry); err != nil {
 				return err
 			}
 		default:
@@ -146,99 +193,92 @@ func processImage(dir, imageTag string) error {
 	return nil
 }
 
-func fetchAuthToken(image string) (string, error) {
-	pullUrl := fmt.Sprintf("https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s:pull", image)
-	resp, err := httpGet(pullUrl)
-	if err != nil {
-		return "", err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to fetch auth token")
-	}
-
-	var data struct {
-		Token string `json:"token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", err
-	}
-
-	return data.Token, nil
+// manifestResponse bundles a fetched manifest's raw bytes with the digest
+// the registry reports serving it under, so callers can verify integrity
+// before trusting (or re-serializing) the content.
+type manifestResponse struct {
+	Bytes  []byte
+	Digest string // value of the Docker-Content-Digest response header, if present
 }
 
-func fetchManifest(token, image, tag string) (*json.Decoder, error) {
-	manifestUrl := fmt.Sprintf("https://registry-1.docker.io/v2/%s/manifests/%s", image, tag)
+func fetchManifest(registry Registry, authHeader, repo, ref string) (*manifestResponse, error) {
+	manifestUrl := fmt.Sprintf("%s/v2/%s/manifests/%s", registry.String(), repo, ref)
 	req, err := http.NewRequest("GET", manifestUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
 	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json, application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.docker.distribution.manifest.v1+json")
 
 	resp, err := httpDo(req)
 	if err != nil {
 		return nil, err
 	}
-	//defer resp.Body.Close()
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("failed to fetch manifest")
 	}
 
-	return json.NewDecoder(resp.Body), nil
-}
-
-func fetchManifestRaw(token, image, tag string) (io.ReadCloser, error) {
-	manifestUrl := fmt.Sprintf("https://registry-1.docker.io/v2/%s/manifests/%s", image, tag)
-	req, err := http.NewRequest("GET", manifestUrl, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json, application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.docker.distribution.manifest.v1+json")
-
-	resp, err := httpDo(req)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	//defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to fetch manifest")
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest != "" {
+		if err := verifyDigest(body, digest); err != nil {
+			return nil, fmt.Errorf("manifest failed integrity check: %w", err)
+		}
 	}
 
-	return resp.Body, nil
+	return &manifestResponse{Bytes: body, Digest: digest}, nil
 }
 
-func handleManifestV1(manifest map[string]interface{}, token, image, dir string) error {
+// handleManifestV1 processes a legacy schemaVersion==1 manifest. Despite its
+// age, the result lands in the same spec-compliant blob storage and
+// index.json as every other schema: each layer is fetched into
+// blobs/sha256/<hex>, the manifest itself is stored as a blob, and an
+// index.json entry is added pointing at it, so V1-tagged images remain
+// consumable by skopeo/crane/podman load alongside everything else.
+func handleManifestV1(manifest map[string]interface{}, manifestBytes []byte, registry Registry, authHeader, repo, dir, refName string, pool *downloadPool) error {
 	var manifestV1 ManifestV1
-	manifestBytes, err := json.Marshal(manifest)
+	rawManifest, err := json.Marshal(manifest)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(manifestBytes, &manifestV1); err != nil {
+	if err := json.Unmarshal(rawManifest, &manifestV1); err != nil {
 		return err
 	}
 
-	fmt.Printf("Downloading '%s' (%d layers)...\n", image, len(manifestV1.FsLayers))
-	for i, layer := range manifestV1.FsLayers {
-		layerPath := filepath.Join(dir, fmt.Sprintf("layer-%d.tar.gz", i))
-		if err := downloadLayer(token, image, layer.BlobSum, layerPath); err != nil {
-			return err
-		}
+	fmt.Printf("Downloading '%s' (%d layers)...\n", repo, len(manifestV1.FsLayers))
+	for _, layer := range manifestV1.FsLayers {
+		layerPath := filepath.Join(dir, "blobs", "sha256", strings.ReplaceAll(layer.BlobSum, "sha256:", ""))
+		pool.submit(downloadJob{
+			registry: registry,
+			auth:     authHeader,
+			repo:     repo,
+			digest:   layer.BlobSum,
+			path:     layerPath,
+		})
 	}
 
-	return nil
+	digest, size, err := writeBlob(dir, manifestBytes)
+	if err != nil {
+		return err
+	}
+	entry := indexDescriptor{
+		MediaType:   "application/vnd.docker.distribution.manifest.v1+json",
+		Digest:      digest,
+		Size:        size,
+		Annotations: map[string]string{refNameAnnotation: refName},
+	}
+	return appendIndexEntry(dir, entry)
 }
 
-func handleManifestV2(manifest map[string]interface{}, token, image, dir string) error {
+func handleManifestV2(manifest map[string]interface{}, registry Registry, authHeader, repo, dir string, pool *downloadPool) error {
 	var manifestV2 ManifestV2
 	manifestBytes, err := json.Marshal(manifest)
 	if err != nil {
@@ -248,21 +288,36 @@ func handleManifestV2(manifest map[string]interface{}, token, image, dir string)
 		return err
 	}
 
-	//fmt.Println("ManifestV2 in handleManifestV2:")
-	//fmt.Println(string(manifestBytes))
+	blobDir := filepath.Join(dir, "blobs", "sha256")
 
-	fmt.Printf("Downloading '%s' (%d layers)...\n", image, len(manifestV2.Layers))
+	if manifestV2.Config.Digest != "" {
+		pool.submit(downloadJob{
+			registry: registry,
+			auth:     authHeader,
+			repo:     repo,
+			digest:   manifestV2.Config.Digest,
+			size:     manifestV2.Config.Size,
+			path:     filepath.Join(blobDir, strings.ReplaceAll(manifestV2.Config.Digest, "sha256:", "")),
+		})
+	}
+
+	fmt.Printf("Downloading '%s' (%d layers)...\n", repo, len(manifestV2.Layers))
 	for _, layer := range manifestV2.Layers {
-		layerPath := filepath.Join(filepath.Join(dir, "blobs", "sha256"), strings.ReplaceAll(layer.Digest, "sha256:", ""))
-		if err := downloadLayer(token, image, layer.Digest, layerPath); err != nil {
-			return err
-		}
+		layerPath := filepath.Join(blobDir, strings.ReplaceAll(layer.Digest, "sha256:", ""))
+		pool.submit(downloadJob{
+			registry: registry,
+			auth:     authHeader,
+			repo:     repo,
+			digest:   layer.Digest,
+			size:     layer.Size,
+			path:     layerPath,
+		})
 	}
 
 	return nil
 }
 
-func handleManifestList(manifest map[string]interface{}, token, image, dir string) error {
+func handleManifestList(manifest map[string]interface{}, registry Registry, authHeader, repo, dir, refName string, platform Platform, pool *downloadPool) error {
 	var manifestList ManifestList
 	manifestBytes, err := json.Marshal(manifest)
 	if err != nil {
@@ -272,180 +327,61 @@ func handleManifestList(manifest map[string]interface{}, token, image, dir strin
 		return err
 	}
 
-	//fmt.Printf("ManifestList in handleManifestList: %s\n", string(manifestBytes))
-
-	targetArch := os.Getenv("TARGETARCH")
-	if targetArch == "" {
-		targetArch = "amd64"
+	match, ok := selectManifest(manifestList.Manifests, platform)
+	if !ok {
+		return fmt.Errorf("no manifest for platform %s", platform)
 	}
-	for _, manifestRef := range manifestList.Manifests {
-		if manifestRef.Platform.Architecture == targetArch {
-			toPrint := fmt.Sprintf("{\"schemaVersion\":2,\"manifests\":[{\"mediaType\":\"application/vnd.oci.image.manifest.v1+json\",\"digest\":\"%s\",\"size\":%d}]}", manifestRef.Digest, manifestRef.Size)
 
-			err = os.WriteFile(filepath.Join(dir, "index.json"), []byte(toPrint), 0644)
-			if err != nil {
-				panic(err)
-			}
-
-			return handleManifestByDigest(token, image, manifestRef.Digest, dir)
-		}
+	entry := indexDescriptor{
+		MediaType:   match.MediaType,
+		Digest:      match.Digest,
+		Size:        match.Size,
+		Annotations: map[string]string{refNameAnnotation: refName},
+	}
+	if err := appendIndexEntry(dir, entry); err != nil {
+		return err
 	}
-	return errors.New("no matching manifest for target architecture")
+
+	return handleManifestByDigest(registry, authHeader, repo, match.Digest, dir, pool)
 }
 
-func handleManifestByDigest(token, image, digest, dir string) error {
-	manifestJson, err := fetchManifest(token, image, digest)
+func handleManifestByDigest(registry Registry, authHeader, repo, digest, dir string, pool *downloadPool) error {
+	manifestResp, err := fetchManifest(registry, authHeader, repo, digest)
 	if err != nil {
 		return err
 	}
+	if err := verifyDigest(manifestResp.Bytes, digest); err != nil {
+		return fmt.Errorf("manifest %s: %w", digest, err)
+	}
 
 	var manifest map[string]interface{}
-	if err := manifestJson.Decode(&manifest); err != nil {
+	if err := json.Unmarshal(manifestResp.Bytes, &manifest); err != nil {
 		return err
 	}
 
-	//manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
-	//if err != nil {
-	//	return err
-	//}
-	//fmt.Println("Manifest JSON string in handleManifestByDigest:")
-	//fmt.Println(string(manifestBytes))
-
-	//write manifest json to blobs
-	rawManifest, err := fetchManifestRaw(token, image, digest)
-	rawManifestBytes, err := io.ReadAll(rawManifest)
-	blobDir := filepath.Join(dir, "blobs", "sha256")
-	err = os.WriteFile(filepath.Join(blobDir, strings.ReplaceAll(digest, "sha256:", "")), rawManifestBytes, 0644)
-	if err != nil {
-		panic(err)
-	}
-
-	// 获取 config.digest 的值
-	config, ok := manifest["config"].(map[string]interface{})
-	if !ok {
-		return errors.New("invalid config format")
-	}
-	configDigest, ok := config["digest"].(string)
-	if !ok {
-		return errors.New("invalid digest format")
+	// write the manifest's own bytes to blobs, never re-serialized, so its
+	// digest always matches what the registry signed.
+	if _, _, err := writeBlob(dir, manifestResp.Bytes); err != nil {
+		return err
 	}
-	fmt.Println("Config digest:", configDigest)
-	err = downloadConfig(token, image, configDigest, blobDir)
 
 	mediaType := manifest["mediaType"].(string)
 	switch mediaType {
 	case "application/vnd.docker.distribution.manifest.v2+json", "application/vnd.oci.image.manifest.v1+json":
-		return handleManifestV2(manifest, token, image, dir)
+		return handleManifestV2(manifest, registry, authHeader, repo, dir, pool)
 	default:
 		return errors.New("unsupported manifest media type for digest")
 	}
 }
 
-func downloadLayer(token, image, digest, layerPath string) error {
-	layerUrl := fmt.Sprintf("https://registry-1.docker.io/v2/%s/blobs/%s", image, digest)
-	req, err := http.NewRequest("GET", layerUrl, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	resp, err := httpDo(req)
-	if err != nil {
-		return err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("failed to download layer")
-	}
-
-	file, err := os.Create(layerPath)
-	if err != nil {
-		return err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(file)
-
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func downloadConfig(token, image, digest, blobDir string) error {
-	configUrl := fmt.Sprintf("https://registry-1.docker.io/v2/%s/blobs/%s", image, digest)
-	req, err := http.NewRequest("GET", configUrl, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	resp, err := httpDo(req)
-	if err != nil {
-		return err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("failed to download config")
-	}
-
-	file, err := os.Create(path.Join(blobDir, strings.ReplaceAll(digest, "sha256:", "")))
-	if err != nil {
-		return err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(file)
-
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func httpGet(urlIn string) (*http.Response, error) {
-	client := &http.Client{}
-	if proxyURL := os.Getenv("HTTP_PROXY"); proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			return nil, err
-		}
-		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxy)}
+	req, err := http.NewRequest("GET", urlIn, nil)
+	if err != nil {
+		return nil, err
 	}
-	return client.Get(urlIn)
+	return httpDo(req)
 }
 
 func httpDo(req *http.Request) (*http.Response, error) {
-
-	//fmt.Println("httpDo: req.URL: ", req.URL)
-
-	client := &http.Client{}
-	if proxyURL := os.Getenv("HTTP_PROXY"); proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			return nil, err
-		}
-		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxy)}
-	}
-	return client.Do(req)
+	return defaultTransport.do(req.URL.Host, req)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies a specific OS/architecture/variant combination, as
+// found in an OCI image index's per-manifest "platform" object.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+	OSVersion    string `json:"os.version,omitempty"`
+}
+
+// String renders platform as "os/arch[/variant]", the form accepted by
+// --platform.
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// armVariantPreference lists, in preference order, the arm variants that
+// can satisfy a request for the given one, per the containerd platform
+// matcher: a v8 host can run v7 and v6 images, a v7 host can run v6, etc.
+var armVariantPreference = map[string][]string{
+	"v8": {"v8", "v7", "v6"},
+	"v7": {"v7", "v6"},
+	"v6": {"v6"},
+}
+
+// ParsePlatform parses a single "os/arch[/variant]" specifier.
+func ParsePlatform(spec string) (Platform, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", spec)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// ParsePlatforms parses a comma-separated --platform value into one
+// Platform per entry, so a single invocation can pull several platforms of
+// the same image list.
+func ParsePlatforms(spec string) ([]Platform, error) {
+	var platforms []Platform
+	for _, s := range strings.Split(spec, ",") {
+		p, err := ParsePlatform(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// defaultPlatform is the platform targeted when --platform isn't given: the
+// one this binary was built for.
+func defaultPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// resolvePlatforms parses the --platform flag value, falling back to
+// defaultPlatform when it's empty.
+func resolvePlatforms(spec string) ([]Platform, error) {
+	if spec == "" {
+		return []Platform{defaultPlatform()}, nil
+	}
+	return ParsePlatforms(spec)
+}
+
+// isAttestationManifest reports whether a manifest-list entry is a
+// BuildKit-produced attestation manifest (platform "unknown/unknown", with
+// a "vnd.docker.reference.type: attestation-manifest" annotation). These
+// carry no image content and must never be selected.
+func isAttestationManifest(annotations map[string]string) bool {
+	return annotations["vnd.docker.reference.type"] == "attestation-manifest"
+}
+
+// armVariants returns the variant preference list to try for target,
+// narrowest-compatible first, or just target.Variant for non-arm
+// architectures.
+func armVariants(target Platform) []string {
+	variant := target.Variant
+	if variant == "" {
+		switch target.Architecture {
+		case "arm64":
+			variant = "v8"
+		case "arm":
+			variant = "v7"
+		default:
+			return []string{""}
+		}
+	}
+	if fallbacks, ok := armVariantPreference[variant]; ok {
+		return fallbacks
+	}
+	return []string{variant}
+}
+
+// selectManifest picks the best entry of candidates for target: an exact
+// OS+arch+variant match first, then an OS+arch match ignoring variant,
+// skipping any attestation manifests along the way.
+func selectManifest(candidates []manifestListEntry, target Platform) (manifestListEntry, bool) {
+	for _, variant := range armVariants(target) {
+		for _, c := range candidates {
+			if isAttestationManifest(c.Annotations) {
+				continue
+			}
+			if c.Platform.OS == target.OS && c.Platform.Architecture == target.Architecture && c.Platform.Variant == variant {
+				return c, true
+			}
+		}
+	}
+
+	for _, c := range candidates {
+		if isAttestationManifest(c.Annotations) {
+			continue
+		}
+		if c.Platform.OS == target.OS && c.Platform.Architecture == target.Architecture {
+			return c, true
+		}
+	}
+
+	return manifestListEntry{}, false
+}
@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestSelectManifest(t *testing.T) {
+	candidates := []manifestListEntry{
+		{Digest: "sha256:amd64", Platform: Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:armv7", Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:armv6", Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{
+			Digest:      "sha256:attestation",
+			Platform:    Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		target     Platform
+		wantDigest string
+		wantOK     bool
+	}{
+		{
+			name:       "exact match",
+			target:     Platform{OS: "linux", Architecture: "amd64"},
+			wantDigest: "sha256:amd64",
+			wantOK:     true,
+		},
+		{
+			name:       "arm v8 falls back to v7 when v8 is unavailable",
+			target:     Platform{OS: "linux", Architecture: "arm", Variant: "v8"},
+			wantDigest: "sha256:armv7",
+			wantOK:     true,
+		},
+		{
+			name:       "arm with no variant infers v7 and falls back",
+			target:     Platform{OS: "linux", Architecture: "arm"},
+			wantDigest: "sha256:armv7",
+			wantOK:     true,
+		},
+		{
+			name:   "architecture mismatch is never satisfied by variant fallback",
+			target: Platform{OS: "linux", Architecture: "arm64", Variant: "v7"},
+			wantOK: false,
+		},
+		{
+			name:   "attestation manifests are never selected",
+			target: Platform{OS: "unknown", Architecture: "unknown"},
+			wantOK: false,
+		},
+		{
+			name:   "no matching platform",
+			target: Platform{OS: "windows", Architecture: "amd64"},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectManifest(candidates, tt.target)
+			if ok != tt.wantOK {
+				t.Fatalf("selectManifest(%v) ok = %v, want %v", tt.target, ok, tt.wantOK)
+			}
+			if ok && got.Digest != tt.wantDigest {
+				t.Errorf("selectManifest(%v) digest = %s, want %s", tt.target, got.Digest, tt.wantDigest)
+			}
+		})
+	}
+}
+
+func TestArmVariantPreferenceOrdering(t *testing.T) {
+	tests := []struct {
+		variant string
+		want    []string
+	}{
+		{variant: "v8", want: []string{"v8", "v7", "v6"}},
+		{variant: "v7", want: []string{"v7", "v6"}},
+		{variant: "v6", want: []string{"v6"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.variant, func(t *testing.T) {
+			got := armVariantPreference[tt.variant]
+			if len(got) != len(tt.want) {
+				t.Fatalf("armVariantPreference[%q] = %v, want %v", tt.variant, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("armVariantPreference[%q][%d] = %q, want %q", tt.variant, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
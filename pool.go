@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// downloadJob is one blob fetch to perform, verified against digest once
+// written to path.
+type downloadJob struct {
+	registry Registry
+	auth     string
+	repo     string
+	digest   string
+	size     int64
+	path     string
+}
+
+// downloadPool runs blob downloads concurrently across every image given on
+// the command line, deduplicating by digest so a base layer shared between
+// images is only fetched once.
+type downloadPool struct {
+	jobs     chan downloadJob
+	wg       sync.WaitGroup
+	progress *progressReporter
+
+	mu     sync.Mutex
+	queued map[string]bool
+	err    error
+}
+
+// workerCount resolves the -j flag, falling back to GOMAXPROCS when unset.
+func workerCount(flagValue int) int {
+	if flagValue > 0 {
+		return flagValue
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func newDownloadPool(workers int) *downloadPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &downloadPool{
+		jobs:     make(chan downloadJob, workers*4),
+		progress: newProgressReporter(),
+		queued:   map[string]bool{},
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *downloadPool) worker() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		err := downloadBlob(job.registry, job.auth, job.repo, job.digest, job.path, p.progress)
+		p.progress.blobDone()
+		if err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = fmt.Errorf("%s: %w", job.digest, err)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// submit enqueues a blob download unless its digest has already been queued
+// during this invocation.
+func (p *downloadPool) submit(job downloadJob) {
+	p.mu.Lock()
+	if p.queued[job.digest] {
+		p.mu.Unlock()
+		return
+	}
+	p.queued[job.digest] = true
+	p.mu.Unlock()
+
+	p.progress.add(job.size)
+	p.jobs <- job
+}
+
+// wait closes the queue, blocks until every worker has drained it, and
+// returns the first error any job encountered, if any.
+func (p *downloadPool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	p.progress.finish()
+	return p.err
+}
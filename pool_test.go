@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestWorkerCount(t *testing.T) {
+	if got := workerCount(4); got != 4 {
+		t.Errorf("workerCount(4) = %d, want 4", got)
+	}
+	if got := workerCount(0); got < 1 {
+		t.Errorf("workerCount(0) = %d, want >= 1 (GOMAXPROCS fallback)", got)
+	}
+}
+
+// TestDownloadPoolSubmitDedupsByDigest exercises submit() directly, without
+// starting any workers, so duplicate enqueues of the same digest can be
+// observed on the jobs channel before anything drains it.
+func TestDownloadPoolSubmitDedupsByDigest(t *testing.T) {
+	p := &downloadPool{
+		jobs:     make(chan downloadJob, 10),
+		progress: newProgressReporter(),
+		queued:   map[string]bool{},
+	}
+	t.Cleanup(p.progress.finish)
+
+	shared := downloadJob{digest: "sha256:shared", path: "/tmp/a"}
+	other := downloadJob{digest: "sha256:other", path: "/tmp/b"}
+
+	p.submit(shared)
+	p.submit(shared) // same digest again, e.g. a base layer shared by two images
+	p.submit(other)
+
+	if got := len(p.jobs); got != 2 {
+		t.Fatalf("queued jobs = %d, want 2 (duplicate digest should be dropped)", got)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[(<-p.jobs).digest] = true
+	}
+	if !seen[shared.digest] || !seen[other.digest] {
+		t.Errorf("queued digests = %v, want both %q and %q present once", seen, shared.digest, other.digest)
+	}
+}
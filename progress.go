@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter renders a single, periodically-rewritten stderr line
+// showing aggregate download throughput while a downloadPool works through
+// its queue.
+type progressReporter struct {
+	totalBlobs int64
+	doneBlobs  int64
+	totalBytes int64 // sum of known blob sizes; blobs with unknown size contribute 0
+	written    int64
+	start      time.Time
+	stopc      chan struct{}
+	donec      chan struct{}
+}
+
+func newProgressReporter() *progressReporter {
+	p := &progressReporter{start: time.Now(), stopc: make(chan struct{}), donec: make(chan struct{})}
+	go p.loop()
+	return p
+}
+
+// add registers one more blob (of the given size, if known) as queued.
+func (p *progressReporter) add(size int64) {
+	atomic.AddInt64(&p.totalBlobs, 1)
+	if size > 0 {
+		atomic.AddInt64(&p.totalBytes, size)
+	}
+}
+
+// skip marks an already-complete blob as done. Its size is counted as
+// written, so it is included in the byte total and the displayed rate.
+func (p *progressReporter) skip(size int64) {
+	atomic.AddInt64(&p.totalBlobs, 1)
+	atomic.AddInt64(&p.doneBlobs, 1)
+	atomic.AddInt64(&p.written, size)
+}
+
+// blobDone marks one queued blob as finished downloading.
+func (p *progressReporter) blobDone() {
+	atomic.AddInt64(&p.doneBlobs, 1)
+}
+
+// counter returns a writer that tallies bytes written to it, for wiring
+// into an io.MultiWriter alongside the destination file.
+func (p *progressReporter) counter() io.Writer {
+	return progressCounter{p}
+}
+
+type progressCounter struct{ p *progressReporter }
+
+func (c progressCounter) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.p.written, int64(len(b)))
+	return len(b), nil
+}
+
+func (p *progressReporter) loop() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.render()
+		case <-p.stopc:
+			p.render()
+			close(p.donec)
+			return
+		}
+	}
+}
+
+func (p *progressReporter) render() {
+	done := atomic.LoadInt64(&p.doneBlobs)
+	total := atomic.LoadInt64(&p.totalBlobs)
+	written := atomic.LoadInt64(&p.written)
+	totalBytes := atomic.LoadInt64(&p.totalBytes)
+
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+	rate := float64(written) / elapsed
+
+	line := fmt.Sprintf("\rdownloading: %d/%d blobs, %.1f MB, %.2f MB/s", done, total, float64(written)/1e6, rate/1e6)
+	if totalBytes > written && rate > 0 {
+		eta := time.Duration(float64(totalBytes-written)/rate) * time.Second
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprint(os.Stderr, line+"    ")
+}
+
+// finish stops the render loop, prints a final line, and moves to a fresh
+// line so subsequent output doesn't overwrite it.
+func (p *progressReporter) finish() {
+	close(p.stopc)
+	<-p.donec
+	fmt.Fprintln(os.Stderr)
+}
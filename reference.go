@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Registry identifies the OCI distribution API host that serves a reference.
+type Registry struct {
+	Host string // e.g. "registry-1.docker.io", "ghcr.io", "localhost:5000"
+}
+
+// String returns the registry's base URL for the OCI distribution API.
+func (r Registry) String() string {
+	return "https://" + r.Host
+}
+
+// Reference is a parsed image reference of the form
+// [registry[:port]/]repository[:tag][@sha256:HEX].
+type Reference struct {
+	Registry   Registry
+	Repository string
+	Tag        string
+	Digest     string // "sha256:HEX", empty unless the reference pinned a digest
+}
+
+// defaultRegistry is substituted when a reference has no explicit host,
+// matching Docker Hub's historical behavior.
+const defaultRegistry = "registry-1.docker.io"
+
+// Target returns the manifest path segment to fetch: the pinned digest when
+// present, otherwise the tag.
+func (r Reference) Target() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// ParseReference parses an image reference the same way
+// go-containerregistry's name.ParseReference does: an optional
+// registry[:port] host, a repository path, an optional tag, and an
+// optional pinned "@sha256:HEX" digest.
+func ParseReference(raw string) (Reference, error) {
+	if raw == "" {
+		return Reference{}, fmt.Errorf("empty image reference")
+	}
+
+	host, rest := splitDomain(raw)
+	switch host {
+	case "":
+		host = defaultRegistry
+	case "docker.io", "index.docker.io":
+		// Both are valid Docker Hub spellings and must be treated exactly
+		// like the no-host case below, including the "library/" prefix.
+		host = defaultRegistry
+	}
+
+	var digest string
+	if at := strings.Index(rest, "@"); at != -1 {
+		rest, digest = rest[:at], rest[at+1:]
+		if !strings.HasPrefix(digest, "sha256:") {
+			return Reference{}, fmt.Errorf("unsupported digest algorithm in %q", digest)
+		}
+	}
+
+	repo := rest
+	tag := "latest"
+	if idx := strings.LastIndex(rest, ":"); idx != -1 && !strings.Contains(rest[idx:], "/") {
+		repo, tag = rest[:idx], rest[idx+1:]
+	}
+
+	// Docker Hub's official images live under "library/".
+	if host == defaultRegistry && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+
+	return Reference{
+		Registry:   Registry{Host: host},
+		Repository: repo,
+		Tag:        tag,
+		Digest:     digest,
+	}, nil
+}
+
+// splitDomain splits the leading path segment off a reference as its host
+// when that segment looks like a registry host rather than the first
+// component of a repository path; otherwise host is empty and rest is the
+// whole reference. It follows the same heuristic as go-containerregistry's
+// name.ParseReference: a segment is a host if it contains a '.', a ':', or
+// is literally "localhost".
+func splitDomain(ref string) (host, rest string) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) < 2 {
+		return "", ref
+	}
+	first := parts[0]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first, parts[1]
+	}
+	return "", ref
+}
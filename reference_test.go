@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Reference
+		wantErr bool
+	}{
+		{
+			name: "bare name defaults to docker hub and library/",
+			raw:  "nginx",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name: "bare name with tag",
+			raw:  "nginx:1.27",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "1.27"},
+		},
+		{
+			name: "explicit docker.io spelling still gets library/ prefix",
+			raw:  "docker.io/nginx",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name: "explicit index.docker.io spelling still gets library/ prefix",
+			raw:  "index.docker.io/nginx:1.27",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "1.27"},
+		},
+		{
+			name: "docker.io with namespaced repository is left untouched",
+			raw:  "docker.io/library/nginx",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name: "third-party registry is untouched",
+			raw:  "ghcr.io/owner/repo:v1",
+			want: Reference{Registry: Registry{Host: "ghcr.io"}, Repository: "owner/repo", Tag: "v1"},
+		},
+		{
+			name: "pinned digest",
+			raw:  "nginx@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			want: Reference{Registry: Registry{Host: defaultRegistry}, Repository: "library/nginx", Tag: "latest", Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		},
+		{
+			name:    "empty reference",
+			raw:     "",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported digest algorithm",
+			raw:     "nginx@md5:abc",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReference(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReference(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseReference(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
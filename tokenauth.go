@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// challenge describes the authentication scheme advertised by a registry's
+// Www-Authenticate header on an unauthenticated request.
+type challenge struct {
+	scheme  string // "Bearer" or "Basic"
+	realm   string
+	service string
+}
+
+// tokenCache memoizes the Authorization header value by registry+scope so
+// that repeated pulls against the same repository (the manifest, the
+// config, and every layer) don't re-authenticate for every blob.
+type tokenCache struct {
+	mu      sync.Mutex
+	headers map[string]string
+}
+
+var authTokenCache = &tokenCache{headers: map[string]string{}}
+
+func (c *tokenCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.headers[key]
+	return h, ok
+}
+
+func (c *tokenCache) set(key, header string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers[key] = header
+}
+
+func (c *tokenCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.headers, key)
+}
+
+// probeChallenge issues GET /v2/ against the registry and parses the
+// Www-Authenticate header returned for an unauthenticated request. It
+// returns a nil challenge when the registry allows anonymous access.
+func probeChallenge(registry Registry) (*challenge, error) {
+	resp, err := httpGet(registry.String() + "/v2/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("unexpected status probing %s: %s", registry.Host, resp.Status)
+	}
+
+	header := resp.Header.Get("Www-Authenticate")
+	if header == "" {
+		return nil, errors.New("registry did not advertise an authentication scheme")
+	}
+	return parseChallenge(header)
+}
+
+// parseChallenge parses a Www-Authenticate header such as:
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (*challenge, error) {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, fmt.Errorf("malformed Www-Authenticate header: %q", header)
+	}
+
+	c := &challenge{scheme: scheme}
+	for _, part := range strings.Split(params, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		c.setParam(kv[0], strings.Trim(kv[1], `"`))
+	}
+
+	if c.scheme == "Bearer" && c.realm == "" {
+		return nil, fmt.Errorf("bearer challenge missing realm: %q", header)
+	}
+	return c, nil
+}
+
+func (c *challenge) setParam(key, value string) {
+	switch key {
+	case "realm":
+		c.realm = value
+	case "service":
+		c.service = value
+	}
+}
+
+// fetchAuthToken authenticates against registry for the given scope (e.g.
+// "repository:library/nginx:pull") and returns the Authorization header
+// value to send on subsequent requests. It returns an empty string when the
+// registry allows anonymous access.
+func fetchAuthToken(registry Registry, scope string) (string, error) {
+	cacheKey := registry.Host + "|" + scope
+	if header, ok := authTokenCache.get(cacheKey); ok {
+		return header, nil
+	}
+
+	auth, err := DefaultKeychain.Resolve(registry)
+	if err != nil {
+		return "", err
+	}
+
+	ch, err := probeChallenge(registry)
+	if err != nil {
+		return "", err
+	}
+	if ch == nil {
+		return "", nil
+	}
+
+	var header string
+	switch ch.scheme {
+	case "Bearer":
+		header, err = fetchBearerToken(*ch, scope, auth)
+	case "Basic":
+		if auth.Username == "" {
+			return "", fmt.Errorf("%s requires credentials but none were found in the keychain", registry.Host)
+		}
+		header = "Basic " + basicAuthToken(auth)
+	default:
+		return "", fmt.Errorf("unsupported auth scheme %q", ch.scheme)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	authTokenCache.set(cacheKey, header)
+	return header, nil
+}
+
+// refreshAuthToken discards any cached Authorization header for
+// registry+scope and re-authenticates from scratch. Bearer tokens are
+// typically short-lived (Docker Hub defaults to 300s), so a pull spanning
+// many large or resumed blobs can outlive the token it started with; callers
+// that see a 401 mid-download should use this to retry once with a fresh
+// token rather than failing the blob outright.
+func refreshAuthToken(registry Registry, scope string) (string, error) {
+	authTokenCache.invalidate(registry.Host + "|" + scope)
+	return fetchAuthToken(registry, scope)
+}
+
+func fetchBearerToken(ch challenge, scope string, auth AuthConfig) (string, error) {
+	realmURL, err := url.Parse(ch.realm)
+	if err != nil {
+		return "", err
+	}
+	q := realmURL.Query()
+	if ch.service != "" {
+		q.Set("service", ch.service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	realmURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realmURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	resp, err := httpDo(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch auth token: %s", resp.Status)
+	}
+
+	var data struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", err
+	}
+	if data.Token != "" {
+		return "Bearer " + data.Token, nil
+	}
+	return "Bearer " + data.AccessToken, nil
+}
+
+func basicAuthToken(auth AuthConfig) string {
+	return base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+}
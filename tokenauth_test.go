@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    challenge
+		wantErr bool
+	}{
+		{
+			name:   "bearer with realm and service",
+			header: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			want:   challenge{scheme: "Bearer", realm: "https://auth.docker.io/token", service: "registry.docker.io"},
+		},
+		{
+			name:   "bearer with extra scope param is ignored",
+			header: `Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:owner/repo:pull"`,
+			want:   challenge{scheme: "Bearer", realm: "https://ghcr.io/token", service: "ghcr.io"},
+		},
+		{
+			name:   "basic with realm only",
+			header: `Basic realm="localhost"`,
+			want:   challenge{scheme: "Basic", realm: "localhost"},
+		},
+		{
+			name:    "missing realm on bearer challenge",
+			header:  `Bearer service="registry.docker.io"`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed header with no scheme",
+			header:  `garbage`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChallenge(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChallenge(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if *got != tt.want {
+				t.Errorf("parseChallenge(%q) = %+v, want %+v", tt.header, *got, tt.want)
+			}
+		})
+	}
+}
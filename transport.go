@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout = 60 * time.Second
+	defaultMaxRetries     = 5
+	defaultMaxBlobBytes   = 20 << 30 // 20 GiB, a generous ceiling against a misbehaving or malicious registry
+)
+
+// Transport builds the *http.Client used for every registry request and
+// centralizes the cross-cutting policy that used to be scattered across
+// httpGet/httpDo: proxy selection, mirror rewriting, TLS verification, and
+// retry/backoff. All call sites (fetchAuthToken, fetchManifest,
+// downloadBlob) route through it via httpGet/httpDo.
+type Transport struct {
+	Mirrors        map[string]string // registry host -> mirror host
+	InsecureHosts  map[string]bool   // registry host -> skip TLS verification
+	RequestTimeout time.Duration
+	MaxRetries     int
+	MaxBlobBytes   int64
+
+	clientsMu sync.Mutex
+	clients   map[string]*http.Client // host -> cached client, so connections are pooled across calls
+}
+
+// defaultTransport is the Transport used by httpGet/httpDo, configured from
+// the process environment at startup.
+var defaultTransport = NewTransportFromEnv()
+
+// NewTransportFromEnv builds a Transport from the process environment: a
+// mirror file (OCI_DOWNLOADER_MIRRORS) and a comma-separated insecure-hosts
+// list (OCI_DOWNLOADER_INSECURE). Proxy selection itself is resolved lazily
+// per-request from HTTP_PROXY/HTTPS_PROXY/NO_PROXY plus any per-registry
+// OCI_DOWNLOADER_PROXY_<HOST> override.
+func NewTransportFromEnv() *Transport {
+	t := &Transport{
+		Mirrors:        map[string]string{},
+		InsecureHosts:  map[string]bool{},
+		RequestTimeout: defaultRequestTimeout,
+		MaxRetries:     defaultMaxRetries,
+		MaxBlobBytes:   defaultMaxBlobBytes,
+		clients:        map[string]*http.Client{},
+	}
+
+	if path := os.Getenv("OCI_DOWNLOADER_MIRRORS"); path != "" {
+		if mirrors, err := loadMirrorConfig(path); err == nil {
+			t.Mirrors = mirrors
+		}
+	}
+
+	for _, host := range strings.Split(os.Getenv("OCI_DOWNLOADER_INSECURE"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			t.InsecureHosts[host] = true
+		}
+	}
+
+	return t
+}
+
+// loadMirrorConfig parses a minimal registries.conf-style mirror file: one
+// "source = mirror" mapping per line, '#' starting a comment. (A full TOML
+// parser isn't worth pulling in for this.)
+func loadMirrorConfig(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source, mirror, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		mirrors[strings.TrimSpace(source)] = strings.TrimSpace(mirror)
+	}
+	return mirrors, nil
+}
+
+// rewriteHost returns the mirror configured for host, or host unchanged
+// when none applies.
+func (t *Transport) rewriteHost(host string) string {
+	if mirror, ok := t.Mirrors[host]; ok {
+		return mirror
+	}
+	return host
+}
+
+// markInsecure records that host should be contacted without verifying its
+// TLS certificate (self-signed registries, local test setups).
+func (t *Transport) markInsecure(host string) {
+	t.InsecureHosts[host] = true
+
+	t.clientsMu.Lock()
+	delete(t.clients, host)
+	t.clientsMu.Unlock()
+}
+
+// clientFor returns the *http.Client for host, building and caching it on
+// first use so that every request to the same host reuses one
+// *http.Transport (and its underlying connection pool) instead of paying a
+// fresh TCP/TLS handshake per call.
+func (t *Transport) clientFor(host string) *http.Client {
+	t.clientsMu.Lock()
+	defer t.clientsMu.Unlock()
+
+	if client, ok := t.clients[host]; ok {
+		return client
+	}
+
+	rt := &http.Transport{
+		Proxy:                 t.proxyFunc(host),
+		ResponseHeaderTimeout: t.RequestTimeout,
+	}
+	if t.InsecureHosts[host] {
+		rt.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	// No client.Timeout: that would bound the whole round trip, including
+	// the body read, and would abort large layer downloads on slow links.
+	// ResponseHeaderTimeout bounds only connect+headers; body reads are
+	// instead bounded by MaxBlobBytes in downloadBlob.
+	client := &http.Client{Transport: rt}
+	t.clients[host] = client
+	return client
+}
+
+// proxyFunc resolves the proxy for requests to host: an
+// OCI_DOWNLOADER_PROXY_<HOST> override if set, else the standard
+// HTTPS_PROXY/HTTP_PROXY/NO_PROXY resolution.
+func (t *Transport) proxyFunc(host string) func(*http.Request) (*url.URL, error) {
+	envVar := "OCI_DOWNLOADER_PROXY_" + sanitizeEnvKey(host)
+	if override := os.Getenv(envVar); override != "" {
+		if proxyURL, err := url.Parse(override); err == nil {
+			return http.ProxyURL(proxyURL)
+		}
+	}
+	return http.ProxyFromEnvironment
+}
+
+func sanitizeEnvKey(host string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", ":", "_", "-", "_").Replace(host))
+}
+
+// do executes req against host's client, retrying 5xx and 429 responses
+// with exponential backoff (honoring a Retry-After header when present) up
+// to MaxRetries times. req must have no body, since it may be resent.
+func (t *Transport) do(host string, req *http.Request) (*http.Response, error) {
+	client := t.clientFor(host)
+
+	var lastErr error
+	for attempt := 0; attempt <= t.MaxRetries; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			time.Sleep(backoffDelay(attempt))
+			continue
+		}
+
+		if !isRetryableStatus(resp.StatusCode) || attempt == t.MaxRetries {
+			return resp, nil
+		}
+
+		wait := retryAfter(resp.Header)
+		resp.Body.Close()
+		if wait <= 0 {
+			wait = backoffDelay(attempt)
+		}
+		time.Sleep(wait)
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("giving up on %s after %d retries", req.URL, t.MaxRetries)
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter parses a Retry-After header (seconds or HTTP-date form),
+// returning 0 when absent or unparseable.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
+
+func backoffDelay(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt)) * 250 * time.Millisecond
+	return base + time.Duration(rand.Int63n(int64(base)+1))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryableStatus(tt.status); got != tt.want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelayGrowsExponentially(t *testing.T) {
+	var prev time.Duration
+	for attempt := 0; attempt < 5; attempt++ {
+		// backoffDelay jitters up to 2x its base, so compare against the
+		// deterministic lower bound (the base itself) rather than the
+		// previous jittered sample.
+		base := time.Duration(1<<uint(attempt)) * 250 * time.Millisecond
+		got := backoffDelay(attempt)
+		if got < base {
+			t.Fatalf("backoffDelay(%d) = %s, want >= %s", attempt, got, base)
+		}
+		if got < prev {
+			t.Errorf("backoffDelay(%d) = %s, want >= previous attempt's base %s", attempt, got, prev)
+		}
+		prev = base
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		want time.Duration
+	}{
+		{name: "absent", val: "", want: 0},
+		{name: "seconds", val: "2", want: 2 * time.Second},
+		{name: "unparseable", val: "not-a-duration-or-date", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.val != "" {
+				h.Set("Retry-After", tt.val)
+			}
+			if got := retryAfter(h); got != tt.want {
+				t.Errorf("retryAfter(%q) = %s, want %s", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTransportDoRetriesOnServerErrorThenSucceeds exercises the retry loop
+// end-to-end against a real server that fails the first two requests with a
+// 503 before succeeding, verifying do() retries rather than surfacing the
+// error immediately.
+func TestTransportDoRetriesOnServerErrorThenSucceeds(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	tr := &Transport{
+		Mirrors:       map[string]string{},
+		InsecureHosts: map[string]bool{},
+		MaxRetries:    defaultMaxRetries,
+		clients:       map[string]*http.Client{},
+	}
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.do(req.URL.Host, req)
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+// TestTransportDoGivesUpAfterMaxRetries checks that a persistently failing
+// server surfaces its last response instead of retrying forever.
+func TestTransportDoGivesUpAfterMaxRetries(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tr := &Transport{
+		Mirrors:       map[string]string{},
+		InsecureHosts: map[string]bool{},
+		MaxRetries:    2,
+		clients:       map[string]*http.Client{},
+	}
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.do(req.URL.Host, req)
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("final status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if attempts != tr.MaxRetries+1 {
+		t.Errorf("attempts = %d, want %d", attempts, tr.MaxRetries+1)
+	}
+}